@@ -0,0 +1,272 @@
+/*
+ * Copyright (c) 2017-2020 The qitmeer developers
+ */
+
+package chain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// BeaconPrecompileAddress is the fixed address contracts call to read
+// verified randomness via randomness(round uint64) bytes32.
+var BeaconPrecompileAddress = common.BytesToAddress([]byte{0x43})
+
+const beaconPollInterval = 10 * time.Second
+
+// BeaconNetwork describes one drand-compatible randomness network: the
+// BLS group public key rounds are verified against, the HTTP chain info
+// endpoint rounds are fetched from, and the QNG height at which this
+// network becomes the active source of randomness.
+type BeaconNetwork struct {
+	Name           string
+	Start          uint64
+	GroupPublicKey []byte
+	ChainInfoURL   string
+}
+
+// beaconRound is a single verified drand round.
+type beaconRound struct {
+	Round      uint64
+	Randomness common.Hash
+}
+
+// drandRoundResponse mirrors drand's /public/latest and /public/{round}
+// HTTP API response.
+type drandRoundResponse struct {
+	Round             uint64 `json:"round"`
+	Randomness        string `json:"randomness"`
+	Signature         string `json:"signature"`
+	PreviousSignature string `json:"previous_signature"`
+}
+
+// BLSVerifier verifies a drand-style BLS signature over message against
+// publicKey. It is an interface so the pairing implementation (e.g.
+// kyber's bls12-381 scheme) can be swapped without touching ingestion
+// logic.
+type BLSVerifier interface {
+	Verify(publicKey, message, signature []byte) error
+}
+
+// Verifier is the BLS verifier used to authenticate incoming beacon
+// rounds. It is set to a real pairing-based verifier (kyberBLSVerifier, in
+// beacon_bls.go) by this package's init, so Ingest works out of the box;
+// operators or tests can still swap it for a stub.
+var Verifier BLSVerifier = rejectAllVerifier{}
+
+type rejectAllVerifier struct{}
+
+func (rejectAllVerifier) Verify(publicKey, message, signature []byte) error {
+	return fmt.Errorf("no BLS verifier configured")
+}
+
+// roundMessage builds the message a drand round's signature is over:
+// H(previousSignature || round), matching the drand chained-beacon
+// construction.
+func roundMessage(round uint64, previousSignature []byte) []byte {
+	h := sha256.New()
+	h.Write(previousSignature)
+	var roundBytes [8]byte
+	binary.BigEndian.PutUint64(roundBytes[:], round)
+	h.Write(roundBytes[:])
+	return h.Sum(nil)
+}
+
+// BeaconNetworks ingests and verifies drand-style randomness rounds from a
+// set of networks activated at different heights, and serves the latest
+// verified randomness for a round to the beacon precompile.
+type BeaconNetworks struct {
+	mu       sync.RWMutex
+	networks []BeaconNetwork
+	rounds   map[string]map[uint64]*beaconRound // per-network round cache
+	lastSeen map[string]uint64                  // per-network last ingested round, for monotonicity
+
+	// currentBlock is the number of the block the EVM is currently
+	// processing, kept in sync by SetCurrentBlock. The randomness
+	// precompile's vm.PrecompiledContract.Run has no block context of its
+	// own, so this is how block.number reaches BeaconEntryForBlock to
+	// select a network for the single-argument randomness(round) ABI.
+	currentBlock uint64
+
+	shutdownChan chan struct{}
+	shutdownWg   sync.WaitGroup
+}
+
+// NewBeaconNetworks builds a BeaconNetworks that selects among networks by
+// their Start height, like the reference beacon-network chain.
+func NewBeaconNetworks(networks []BeaconNetwork) *BeaconNetworks {
+	sorted := make([]BeaconNetwork, len(networks))
+	copy(sorted, networks)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	return &BeaconNetworks{
+		networks:     sorted,
+		rounds:       make(map[string]map[uint64]*beaconRound),
+		lastSeen:     make(map[string]uint64),
+		shutdownChan: make(chan struct{}),
+	}
+}
+
+// BeaconEntryForBlock returns the network active at blockNum: the network
+// with the greatest Start that is <= blockNum.
+func (b *BeaconNetworks) BeaconEntryForBlock(blockNum uint64) (*BeaconNetwork, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var active *BeaconNetwork
+	for i := range b.networks {
+		if b.networks[i].Start > blockNum {
+			break
+		}
+		active = &b.networks[i]
+	}
+	if active == nil {
+		return nil, fmt.Errorf("no beacon network active at height %d", blockNum)
+	}
+	return active, nil
+}
+
+// SetCurrentBlock records blockNum as the block the EVM is about to
+// process. Callers that drive transaction execution (VM.BuildBlock, and
+// VM.applyPrivateTxsInBlock ahead of PrivateStateManager.ApplyBlock) call
+// this with the block's number immediately before applying its
+// transactions.
+func (b *BeaconNetworks) SetCurrentBlock(blockNum uint64) {
+	b.mu.Lock()
+	b.currentBlock = blockNum
+	b.mu.Unlock()
+}
+
+// activeNetwork returns the BeaconNetwork active at the most recently
+// recorded SetCurrentBlock height.
+func (b *BeaconNetworks) activeNetwork() (*BeaconNetwork, error) {
+	b.mu.RLock()
+	blockNum := b.currentBlock
+	b.mu.RUnlock()
+	return b.BeaconEntryForBlock(blockNum)
+}
+
+// Start begins polling every configured network's chain info endpoint for
+// new rounds.
+func (b *BeaconNetworks) Start() {
+	for i := range b.networks {
+		network := b.networks[i]
+		b.shutdownWg.Add(1)
+		go func() {
+			defer b.shutdownWg.Done()
+			b.pollNetwork(network)
+		}()
+	}
+}
+
+func (b *BeaconNetworks) Stop() {
+	close(b.shutdownChan)
+	b.shutdownWg.Wait()
+}
+
+func (b *BeaconNetworks) pollNetwork(network BeaconNetwork) {
+	ticker := time.NewTicker(beaconPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.fetchAndIngest(network); err != nil {
+				log.Error(fmt.Sprintf("BeaconNetworks: %s: %v", network.Name, err))
+			}
+		case <-b.shutdownChan:
+			return
+		}
+	}
+}
+
+func (b *BeaconNetworks) fetchAndIngest(network BeaconNetwork) error {
+	resp, err := http.Get(network.ChainInfoURL + "/public/latest")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	round := &drandRoundResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(round); err != nil {
+		return err
+	}
+	signature, err := hex.DecodeString(round.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %v", err)
+	}
+	previousSignature, err := hex.DecodeString(round.PreviousSignature)
+	if err != nil {
+		return fmt.Errorf("invalid previous signature encoding: %v", err)
+	}
+	return b.Ingest(network, round.Round, signature, previousSignature)
+}
+
+// Ingest verifies a single round's BLS signature against network's group
+// public key and, if valid and newer than the last ingested round for this
+// network, records the round's randomness. Unknown networks, out-of-order
+// rounds, and signatures that fail verification are all rejected.
+//
+// The randomness recorded is sha256(signature), derived locally from the
+// signature this call just verified - never a "randomness" field taken
+// verbatim from the network's response. A server could otherwise return a
+// valid (round, signature) alongside an arbitrary randomness value and
+// have it pass straight through to contracts unverified.
+func (b *BeaconNetworks) Ingest(network BeaconNetwork, round uint64, signature, previousSignature []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	known := false
+	for i := range b.networks {
+		if b.networks[i].Name == network.Name {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return fmt.Errorf("unknown beacon network %s", network.Name)
+	}
+	if last, ok := b.lastSeen[network.Name]; ok && round <= last {
+		return fmt.Errorf("round %d is not newer than last seen round %d for %s", round, last, network.Name)
+	}
+	if err := Verifier.Verify(network.GroupPublicKey, roundMessage(round, previousSignature), signature); err != nil {
+		return fmt.Errorf("BLS verification failed for round %d: %v", round, err)
+	}
+
+	if b.rounds[network.Name] == nil {
+		b.rounds[network.Name] = make(map[uint64]*beaconRound)
+	}
+	randomness := sha256.Sum256(signature)
+	b.rounds[network.Name][round] = &beaconRound{Round: round, Randomness: randomness}
+	b.lastSeen[network.Name] = round
+	return nil
+}
+
+// Randomness returns the verified randomness for round on network, the one
+// BeaconEntryForBlock selected for the height the caller cares about. It
+// deliberately does not search other networks: a contract asking for
+// randomness at a given height must get that height's network, not
+// whichever network happens to have ingested a same-numbered round.
+func (b *BeaconNetworks) Randomness(network *BeaconNetwork, round uint64) (common.Hash, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	rounds, ok := b.rounds[network.Name]
+	if !ok {
+		return common.Hash{}, false
+	}
+	r, ok := rounds[round]
+	if !ok {
+		return common.Hash{}, false
+	}
+	return r.Randomness, true
+}
@@ -0,0 +1,35 @@
+/*
+ * Copyright (c) 2017-2020 The qitmeer developers
+ */
+
+package chain
+
+import (
+	"fmt"
+
+	"github.com/drand/kyber/pairing/bls12381"
+	"github.com/drand/kyber/sign/bls"
+)
+
+// kyberBLSVerifier verifies drand-style BLS12-381 signatures using kyber's
+// pairing implementation, the same scheme the drand reference beacon
+// network uses.
+type kyberBLSVerifier struct {
+	suite *bls12381.Suite
+}
+
+func newKyberBLSVerifier() *kyberBLSVerifier {
+	return &kyberBLSVerifier{suite: bls12381.NewBLS12381Suite()}
+}
+
+func (v *kyberBLSVerifier) Verify(publicKey, message, signature []byte) error {
+	pub := v.suite.G1().Point()
+	if err := pub.UnmarshalBinary(publicKey); err != nil {
+		return fmt.Errorf("invalid beacon group public key: %v", err)
+	}
+	return bls.Verify(v.suite, pub, message, signature)
+}
+
+func init() {
+	Verifier = newKyberBLSVerifier()
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright (c) 2017-2020 The qitmeer developers
+ */
+
+package chain
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// beaconPrecompile exposes BeaconNetworks to contracts as
+// randomness(round uint64) bytes32, callable at BeaconPrecompileAddress.
+// The active network is chosen by BeaconEntryForBlock using the block
+// currently being processed (see BeaconNetworks.SetCurrentBlock), since a
+// plain PrecompiledContract.Run has no block context of its own and the
+// request's ABI takes no blockNumber argument for callers to supply one.
+type beaconPrecompile struct {
+	networks *BeaconNetworks
+}
+
+func (p *beaconPrecompile) RequiredGas(input []byte) uint64 {
+	return 3000
+}
+
+func (p *beaconPrecompile) Run(input []byte) ([]byte, error) {
+	if len(input) != 32 {
+		return nil, fmt.Errorf("beacon precompile: expected 32 bytes (round)")
+	}
+	round := new(big.Int).SetBytes(input).Uint64()
+
+	network, err := p.networks.activeNetwork()
+	if err != nil {
+		return nil, fmt.Errorf("beacon precompile: %v", err)
+	}
+	randomness, ok := p.networks.Randomness(network, round)
+	if !ok {
+		return nil, fmt.Errorf("beacon precompile: round %d is unknown or unverified on network %s", round, network.Name)
+	}
+	return randomness.Bytes(), nil
+}
+
+// RegisterBeaconPrecompile wires networks into c's EVM as the randomness
+// precompile for the given hard forks, via the same plugin registry used
+// by RegisterPrecompile.
+func (c *ETHChain) RegisterBeaconPrecompile(networks *BeaconNetworks, forks []string) error {
+	return c.RegisterPrecompile(BeaconPrecompileAddress, &beaconPrecompile{networks: networks}, forks)
+}
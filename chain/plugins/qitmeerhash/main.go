@@ -0,0 +1,43 @@
+/*
+ * Copyright (c) 2017-2020 The qitmeer developers
+ */
+
+// Package main is a sample precompile plugin, built with
+// `go build -buildmode=plugin -o qitmeerhash.so`, that exposes a
+// Qitmeer-native keccak hash as an EVM precompile so Solidity contracts can
+// verify cross-chain proofs against QNG without an oracle contract.
+package main
+
+import (
+	"github.com/Qitmeer/meerevm/chain"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"golang.org/x/crypto/sha3"
+)
+
+// qitmeerHashAddress is the fixed address this sample is callable at.
+// Operators writing their own plugin should pick an unused address outside
+// the standard 0x1-0x9 precompile range.
+var qitmeerHashAddress = common.BytesToAddress([]byte{0x42})
+
+type qitmeerHashContract struct{}
+
+func (qitmeerHashContract) RequiredGas(input []byte) uint64 {
+	return 60 + uint64(len(input)/32)*12
+}
+
+func (qitmeerHashContract) Run(input []byte) ([]byte, error) {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(input)
+	return h.Sum(nil), nil
+}
+
+type qitmeerHashPlugin struct{}
+
+func (qitmeerHashPlugin) Address() common.Address         { return qitmeerHashAddress }
+func (qitmeerHashPlugin) Contract() vm.PrecompiledContract { return qitmeerHashContract{} }
+func (qitmeerHashPlugin) Forks() []string                  { return []string{"Istanbul", "Berlin", "Cancun"} }
+
+// Precompile is the symbol chain.LoadPrecompilePlugins looks up via the Go
+// plugin package.
+var Precompile chain.PrecompilePlugin = qitmeerHashPlugin{}
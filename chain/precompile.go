@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2017-2020 The qitmeer developers
+ */
+
+package chain
+
+import (
+	"fmt"
+	"path/filepath"
+	"plugin"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// PrecompilePluginSymbol is the exported symbol every precompile plugin
+// built with `go build -buildmode=plugin` must provide so it can be
+// discovered by LoadPrecompilePlugins.
+const PrecompilePluginSymbol = "Precompile"
+
+// PrecompilePlugin is the contract a precompile plugin must satisfy.
+// Address is the fixed address the contract is callable at, Contract is
+// the EVM-side implementation, and Forks lists the hard forks (by
+// go-ethereum's params.Rules naming, e.g. "Istanbul", "Berlin") it should
+// be injected into.
+type PrecompilePlugin interface {
+	Address() common.Address
+	Contract() vm.PrecompiledContract
+	Forks() []string
+}
+
+// precompileTables maps a hard fork name to the go-ethereum precompile
+// table that is active for it, mirroring vm.PrecompiledContractsByzantium
+// and friends.
+var precompileTables = map[string]vm.PrecompiledContracts{
+	"Homestead": vm.PrecompiledContractsHomestead,
+	"Byzantium": vm.PrecompiledContractsByzantium,
+	"Istanbul":  vm.PrecompiledContractsIstanbul,
+	"Berlin":    vm.PrecompiledContractsBerlin,
+	"Cancun":    vm.PrecompiledContractsCancun,
+}
+
+var precompileMu sync.Mutex
+
+// RegisterPrecompile injects p into the precompile table of every hard
+// fork named in forks, making it callable from Solidity at addr without
+// forking meerevm. precompileMu only serializes RegisterPrecompile against
+// itself; the EVM interpreter reads these tables without any lock once
+// execution starts, so callers must finish registering every precompile
+// before the chain starts running transactions (c.Start()), not after.
+func (c *ETHChain) RegisterPrecompile(addr common.Address, p vm.PrecompiledContract, forks []string) error {
+	precompileMu.Lock()
+	defer precompileMu.Unlock()
+
+	for _, fork := range forks {
+		table, ok := precompileTables[fork]
+		if !ok {
+			return fmt.Errorf("unknown precompile fork: %s", fork)
+		}
+		table[addr] = p
+	}
+	log.Info("Registered precompile", "address", addr.String(), "forks", forks)
+	return nil
+}
+
+// LoadPrecompilePlugins scans dir for Go plugins (*.so) exposing a
+// PrecompilePlugin via the "Precompile" symbol and registers each of them
+// on c. It is called once from VM.Initialize, after the plugin directory
+// has been resolved from config and before c.Start() runs - see
+// RegisterPrecompile for why that ordering matters. A missing or empty
+// dir is not an error, so operators who don't use plugins pay no cost.
+func (c *ETHChain) LoadPrecompilePlugins(dir string) error {
+	if len(dir) == 0 {
+		return nil
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "*.so"))
+	if err != nil {
+		return err
+	}
+	for _, path := range matches {
+		if err := c.loadPrecompilePlugin(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ETHChain) loadPrecompilePlugin(path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return fmt.Errorf("open precompile plugin %s: %v", path, err)
+	}
+	sym, err := p.Lookup(PrecompilePluginSymbol)
+	if err != nil {
+		return fmt.Errorf("precompile plugin %s missing %q symbol: %v", path, PrecompilePluginSymbol, err)
+	}
+	// Lookup of an exported variable yields a pointer to it.
+	var pp PrecompilePlugin
+	switch s := sym.(type) {
+	case *PrecompilePlugin:
+		pp = *s
+	case PrecompilePlugin:
+		pp = s
+	}
+	if pp == nil {
+		return fmt.Errorf("precompile plugin %s does not implement PrecompilePlugin", path)
+	}
+	if err := c.RegisterPrecompile(pp.Address(), pp.Contract(), pp.Forks()); err != nil {
+		return fmt.Errorf("register precompile plugin %s: %v", path, err)
+	}
+	log.Info("Loaded precompile plugin", "path", path, "address", pp.Address().String())
+	return nil
+}
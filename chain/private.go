@@ -0,0 +1,221 @@
+/*
+ * Copyright (c) 2017-2020 The qitmeer developers
+ */
+
+package chain
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const privateRootsFile = "privateroots.json"
+
+// persistedPrivateState is the on-disk form of PrivateStateManager's
+// bookkeeping: the private root for every public block that has had private
+// transactions applied against it, and the private transactions recorded
+// for a built-but-not-yet-connected block (see RecordPending). Both survive
+// a restart, so neither requires re-deriving private membership from
+// in-memory state that only the node which built or resolved a given
+// private transaction would have populated.
+type persistedPrivateState struct {
+	Roots   map[common.Hash]common.Hash `json:"roots"`
+	Pending map[common.Hash][][]byte    `json:"pending"`
+}
+
+// PrivateStateManager keeps the private trie that sits alongside ETHChain's
+// public state, the way Quorum maintains a private state next to the
+// public one. It is owned by the evm package (which knows which
+// transactions in a block are private) rather than by MeerChain, and is
+// safe for concurrent use.
+type PrivateStateManager struct {
+	eth      *ETHChain
+	rootPath string
+
+	mu      sync.RWMutex
+	roots   map[common.Hash]common.Hash // public block hash -> private state root
+	pending map[common.Hash][][]byte    // public block hash -> RLP-encoded private txs awaiting ConnectBlock
+}
+
+// NewPrivateStateManager restores (or initializes) the private state
+// bookkeeping from dataDir.
+func NewPrivateStateManager(eth *ETHChain, dataDir string) *PrivateStateManager {
+	m := &PrivateStateManager{
+		eth:      eth,
+		rootPath: filepath.Join(dataDir, privateRootsFile),
+		roots:    make(map[common.Hash]common.Hash),
+		pending:  make(map[common.Hash][][]byte),
+	}
+	if err := m.load(); err != nil {
+		log.Debug(fmt.Sprintf("PrivateStateManager: no existing private state bookkeeping: %v", err))
+	}
+	return m
+}
+
+func (m *PrivateStateManager) load() error {
+	data, err := os.ReadFile(m.rootPath)
+	if err != nil {
+		return err
+	}
+	persisted := persistedPrivateState{}
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if persisted.Roots != nil {
+		m.roots = persisted.Roots
+	}
+	if persisted.Pending != nil {
+		m.pending = persisted.Pending
+	}
+	return nil
+}
+
+// save persists the root mapping and pending private txs. Callers must hold
+// m.mu.
+func (m *PrivateStateManager) save() error {
+	data, err := json.Marshal(persistedPrivateState{Roots: m.roots, Pending: m.pending})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.rootPath, data, 0600)
+}
+
+// RecordPending persists the private transactions belonging to the block
+// BuildBlock just assembled, keyed by that block's hash, so ConnectBlock
+// can apply them to the private trie once (and only if) the block is
+// actually accepted - including after a restart, since this is written to
+// disk immediately rather than kept in memory.
+func (m *PrivateStateManager) RecordPending(blockHash common.Hash, txs []*types.Transaction) error {
+	if len(txs) == 0 {
+		return nil
+	}
+	encoded := make([][]byte, len(txs))
+	for i, tx := range txs {
+		b, err := tx.MarshalBinary()
+		if err != nil {
+			return fmt.Errorf("encode pending private tx %s: %v", tx.Hash().String(), err)
+		}
+		encoded[i] = b
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending[blockHash] = encoded
+	return m.save()
+}
+
+// TakePending returns the private transactions RecordPending stored for
+// blockHash and forgets them, decoding each back into a *types.Transaction.
+// It returns nil, nil if no private transactions were recorded for this
+// block.
+func (m *PrivateStateManager) TakePending(blockHash common.Hash) ([]*types.Transaction, error) {
+	m.mu.Lock()
+	encoded, ok := m.pending[blockHash]
+	var saveErr error
+	if ok {
+		delete(m.pending, blockHash)
+		saveErr = m.save()
+	}
+	m.mu.Unlock()
+	if saveErr != nil {
+		log.Error(fmt.Sprintf("PrivateStateManager: failed to persist pending private txs: %v", saveErr))
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	txs := make([]*types.Transaction, len(encoded))
+	for i, b := range encoded {
+		tx := &types.Transaction{}
+		if err := tx.UnmarshalBinary(b); err != nil {
+			return nil, fmt.Errorf("decode pending private tx: %v", err)
+		}
+		txs[i] = tx
+	}
+	return txs, nil
+}
+
+// StateAt returns the private state as of the public block identified by
+// blockHash. An unknown blockHash (no private transactions applied yet)
+// returns the empty private trie.
+func (m *PrivateStateManager) StateAt(blockHash common.Hash) (*state.StateDB, error) {
+	m.mu.RLock()
+	root := m.roots[blockHash]
+	m.mu.RUnlock()
+	return m.eth.Ether().BlockChain().StateAt(root)
+}
+
+// ApplyBlock executes txs (the private transactions belonging to the
+// block described by header) against the private trie rooted at header's
+// parent, and records the resulting private state root against
+// header.Hash(). It is the private-state counterpart of the public
+// transaction application VM.ConnectBlock otherwise relies on.
+func (m *PrivateStateManager) ApplyBlock(header *types.Header, txs []*types.Transaction) ([]*types.Receipt, common.Hash, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parentRoot := m.roots[header.ParentHash]
+	privateState, err := m.eth.Ether().BlockChain().StateAt(parentRoot)
+	if err != nil {
+		return nil, common.Hash{}, err
+	}
+	if len(txs) == 0 {
+		if parentRoot != (common.Hash{}) {
+			m.roots[header.Hash()] = parentRoot
+			if err := m.save(); err != nil {
+				log.Error(fmt.Sprintf("PrivateStateManager: failed to persist private roots: %v", err))
+			}
+		}
+		return nil, parentRoot, nil
+	}
+
+	bc := m.eth.Ether().BlockChain()
+	chainConfig := bc.Config()
+	gasPool := new(core.GasPool).AddGas(header.GasLimit)
+	var (
+		receipts []*types.Receipt
+		usedGas  uint64
+	)
+	for i, tx := range txs {
+		privateState.Prepare(tx.Hash(), i)
+		receipt, err := core.ApplyTransaction(chainConfig, bc, &header.Coinbase, gasPool, privateState, header, tx, &usedGas, *bc.GetVMConfig())
+		if err != nil {
+			return nil, common.Hash{}, fmt.Errorf("apply private tx %s: %v", tx.Hash().String(), err)
+		}
+		receipts = append(receipts, receipt)
+	}
+
+	newRoot, err := privateState.Commit(chainConfig.IsEIP158(header.Number), false)
+	if err != nil {
+		return nil, common.Hash{}, err
+	}
+	m.roots[header.Hash()] = newRoot
+	if err := m.save(); err != nil {
+		log.Error(fmt.Sprintf("PrivateStateManager: failed to persist private roots: %v", err))
+	}
+	return receipts, newRoot, nil
+}
+
+// Forget drops the private root recorded for blockHash, called when a
+// block is disconnected so a later re-connect recomputes it cleanly.
+func (m *PrivateStateManager) Forget(blockHash common.Hash) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.roots[blockHash]; !ok {
+		return
+	}
+	delete(m.roots, blockHash)
+	if err := m.save(); err != nil {
+		log.Error(fmt.Sprintf("PrivateStateManager: failed to persist private roots: %v", err))
+	}
+}
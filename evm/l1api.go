@@ -0,0 +1,51 @@
+/*
+ * Copyright (c) 2017-2020 The qitmeer developers
+ */
+
+package evm
+
+import (
+	"github.com/Qitmeer/qng-core/rpc/api"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// L1API exposes L1Bridge state over RPC as meer_getL1Head and
+// meer_getPendingDeposits.
+type L1API struct {
+	bridge *L1Bridge
+}
+
+func NewL1API(bridge *L1Bridge) *L1API {
+	return &L1API{bridge: bridge}
+}
+
+// L1HeadResult is the result of meer_getL1Head.
+type L1HeadResult struct {
+	Number uint64      `json:"number"`
+	Hash   common.Hash `json:"hash"`
+}
+
+// GetL1Head returns the last L1 block number and hash the bridge has
+// observed.
+func (a *L1API) GetL1Head() L1HeadResult {
+	number, h := a.bridge.Head()
+	return L1HeadResult{Number: number, Hash: h}
+}
+
+// GetPendingDeposits returns the deposits that have not yet reached the
+// configured L1Confirmations.
+func (a *L1API) GetPendingDeposits() []*L1Deposit {
+	return a.bridge.PendingDeposits()
+}
+
+// APIs returns the RPC API descriptors for the L1 bridge, ready to pass to
+// VM.RegisterAPIs.
+func (b *L1Bridge) APIs() []api.API {
+	return []api.API{
+		{
+			Namespace: "meer",
+			Service:   NewL1API(b),
+			Public:    true,
+		},
+	}
+}
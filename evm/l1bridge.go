@@ -0,0 +1,290 @@
+/*
+ * Copyright (c) 2017-2020 The qitmeer developers
+ */
+
+package evm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// depositEventSignature is the topic0 of the deposit contract's
+// Deposit(bytes32,address,uint256) event: (l1TxHash, depositor, amount).
+var depositEventSignature = crypto.Keccak256Hash([]byte("Deposit(bytes32,address,uint256)"))
+
+// l1CursorFile is the name of the file under the QNG data directory that
+// persists the last L1 block L1Bridge has scanned up to, so a restart
+// resumes scanning instead of replaying from L1DeploymentBlock.
+const l1CursorFile = "l1cursor.json"
+
+const l1PollInterval = 15 * time.Second
+
+// l1Cursor is the on-disk representation of L1Bridge's scan position.
+type l1Cursor struct {
+	Head     uint64      `json:"head"`
+	HeadHash common.Hash `json:"headHash"`
+}
+
+// L1Deposit is a deposit on the L1 chain referenced by a QNG cross-chain
+// transaction, along with how many confirmations it currently has.
+type L1Deposit struct {
+	L1TxHash      common.Hash `json:"l1TxHash"`
+	BlockNumber   uint64      `json:"blockNumber"`
+	Confirmations uint64      `json:"confirmations"`
+}
+
+// L1Bridge watches an external Ethereum endpoint and gates acceptance of
+// TxTypeCrossChainVM transactions on their referenced L1 deposit reaching
+// L1Confirmations, the way rollup deposit inboxes do, instead of relying
+// purely on QNG-side signature checks.
+type L1Bridge struct {
+	client          *ethclient.Client
+	confirmations   uint64
+	deploymentBlock uint64
+	depositContract common.Address
+	cursorPath      string
+
+	mu       sync.RWMutex
+	head     uint64
+	headHash common.Hash
+	deposits map[common.Hash]*L1Deposit
+
+	shutdownChan chan struct{}
+	shutdownWg   sync.WaitGroup
+}
+
+// NewL1Bridge dials endpoint and restores (or initializes) the scan cursor
+// from dataDir. It does not start scanning until Start is called.
+// depositContract is the L1 address whose Deposit(bytes32,address,uint256)
+// events are scanned to discover deposits.
+func NewL1Bridge(endpoint string, confirmations, deploymentBlock uint64, depositContract common.Address, dataDir string) (*L1Bridge, error) {
+	client, err := ethclient.Dial(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("dial L1 endpoint %q: %v", endpoint, err)
+	}
+	b := &L1Bridge{
+		client:          client,
+		confirmations:   confirmations,
+		deploymentBlock: deploymentBlock,
+		depositContract: depositContract,
+		cursorPath:      filepath.Join(dataDir, l1CursorFile),
+		deposits:        make(map[common.Hash]*L1Deposit),
+		shutdownChan:    make(chan struct{}),
+	}
+	if cursor, err := b.loadCursor(); err == nil {
+		b.head = cursor.Head
+		b.headHash = cursor.HeadHash
+	} else {
+		b.head = deploymentBlock
+	}
+	return b, nil
+}
+
+func (b *L1Bridge) loadCursor() (*l1Cursor, error) {
+	data, err := os.ReadFile(b.cursorPath)
+	if err != nil {
+		return nil, err
+	}
+	cursor := &l1Cursor{}
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+func (b *L1Bridge) saveCursor() error {
+	data, err := json.Marshal(&l1Cursor{Head: b.head, HeadHash: b.headHash})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(b.cursorPath, data, 0600)
+}
+
+// Start begins polling the L1 endpoint for new heads, rewinding the cursor
+// by the confirmation depth whenever its recorded head is reorged out.
+func (b *L1Bridge) Start() {
+	b.shutdownWg.Add(1)
+	go func() {
+		defer b.shutdownWg.Done()
+		ticker := time.NewTicker(l1PollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := b.sync(); err != nil {
+					log.Error(fmt.Sprintf("L1Bridge: sync failed: %v", err))
+				}
+			case <-b.shutdownChan:
+				return
+			}
+		}
+	}()
+}
+
+func (b *L1Bridge) Stop() {
+	close(b.shutdownChan)
+	b.shutdownWg.Wait()
+}
+
+// sync scans for new deposit events up to the current L1 chain head,
+// rewinding first if the previously recorded head has been reorged out. A
+// tick that discovers a reorg only rewinds and rescans the affected range;
+// it does not advance the cursor past the rewound point on that tick, so a
+// still-unfolding reorg is not papered over by jumping straight to the new
+// tip. The next tick scans forward from the rewound point to the then
+// current tip.
+func (b *L1Bridge) sync() error {
+	ctx, cancel := context.WithTimeout(context.Background(), l1PollInterval)
+	defer cancel()
+
+	latest, err := b.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.head > 0 && b.headHash != (common.Hash{}) {
+		onChain, err := b.client.HeaderByNumber(ctx, new(big.Int).SetUint64(b.head))
+		if err == nil && onChain.Hash() != b.headHash {
+			rewoundFrom := b.head
+			// Our recorded head is no longer canonical: rewind by the
+			// full confirmation depth and rescan that range before
+			// trusting it again.
+			if b.head > b.confirmations {
+				b.head -= b.confirmations
+			} else {
+				b.head = b.deploymentBlock
+			}
+			b.headHash = common.Hash{}
+			log.Warn("L1Bridge: detected L1 reorg, rewinding cursor", "newHead", b.head)
+
+			// Deposits recorded from the orphaned range [newHead+1,
+			// rewoundFrom] may not exist on the canonical chain at all -
+			// drop them before rescanning so a deposit that was reorged
+			// away can't keep accumulating confirmations and pass
+			// RequireConfirmedDeposit. Any of them still canonical are
+			// re-added by the rescan below.
+			for h, d := range b.deposits {
+				if d.BlockNumber > b.head {
+					delete(b.deposits, h)
+				}
+			}
+
+			if err := b.scanDeposits(ctx, b.head+1, rewoundFrom); err != nil {
+				return err
+			}
+			return b.saveCursor()
+		}
+	}
+
+	scanFrom := b.head + 1
+	if b.head == 0 {
+		scanFrom = b.deploymentBlock
+	}
+	targetHead := latest.Number.Uint64()
+	if scanFrom <= targetHead {
+		if err := b.scanDeposits(ctx, scanFrom, targetHead); err != nil {
+			return err
+		}
+	}
+
+	b.head = targetHead
+	b.headHash = latest.Hash()
+
+	for h, d := range b.deposits {
+		if b.head >= d.BlockNumber {
+			d.Confirmations = b.head - d.BlockNumber
+		} else {
+			d.Confirmations = 0
+		}
+		b.deposits[h] = d
+	}
+
+	return b.saveCursor()
+}
+
+// scanDeposits fetches Deposit events emitted by depositContract in
+// [fromBlock, toBlock] and records each one keyed by its L1 transaction
+// hash. Callers hold b.mu.
+func (b *L1Bridge) scanDeposits(ctx context.Context, fromBlock, toBlock uint64) error {
+	if fromBlock > toBlock {
+		return nil
+	}
+	query := ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(fromBlock),
+		ToBlock:   new(big.Int).SetUint64(toBlock),
+		Addresses: []common.Address{b.depositContract},
+		Topics:    [][]common.Hash{{depositEventSignature}},
+	}
+	logs, err := b.client.FilterLogs(ctx, query)
+	if err != nil {
+		return fmt.Errorf("filter deposit logs [%d,%d]: %v", fromBlock, toBlock, err)
+	}
+	for _, l := range logs {
+		b.deposits[l.TxHash] = &L1Deposit{
+			L1TxHash:    l.TxHash,
+			BlockNumber: l.BlockNumber,
+		}
+	}
+	return nil
+}
+
+// RequireConfirmedDeposit gates acceptance of a cross-chain tx on its
+// referenced L1 deposit having reached L1Confirmations. The deposit
+// reference is the first 32 bytes of the wrapped transaction's calldata,
+// by convention the L1 deposit transaction's hash.
+func (b *L1Bridge) RequireConfirmedDeposit(tx *types.Transaction) error {
+	data := tx.Data()
+	if len(data) < common.HashLength {
+		return fmt.Errorf("cross-chain tx missing L1 deposit reference")
+	}
+	l1TxHash := common.BytesToHash(data[:common.HashLength])
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	deposit, ok := b.deposits[l1TxHash]
+	if !ok {
+		return fmt.Errorf("unknown or unconfirmed L1 deposit %s", l1TxHash.String())
+	}
+	if deposit.Confirmations < b.confirmations {
+		return fmt.Errorf("L1 deposit %s has %d/%d confirmations", l1TxHash.String(), deposit.Confirmations, b.confirmations)
+	}
+	return nil
+}
+
+// Head returns the last L1 block number and hash L1Bridge has observed.
+func (b *L1Bridge) Head() (uint64, common.Hash) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.head, b.headHash
+}
+
+// PendingDeposits returns the deposits that have not yet reached
+// L1Confirmations.
+func (b *L1Bridge) PendingDeposits() []*L1Deposit {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	pending := make([]*L1Deposit, 0)
+	for _, d := range b.deposits {
+		if d.Confirmations < b.confirmations {
+			pending = append(pending, d)
+		}
+	}
+	return pending
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2017-2020 The qitmeer developers
+ */
+
+package evm
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// filePayloadStore keeps private payloads as files named by their keccak256
+// hash under a local directory. It exists for development and single-node
+// testing of private transactions; production deployments should point
+// PRIVATE_CONFIG at a real transaction manager via httpPayloadStore.
+type filePayloadStore struct {
+	dir string
+}
+
+func newFilePayloadStore(dir string) (*filePayloadStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &filePayloadStore{dir: dir}, nil
+}
+
+func (s *filePayloadStore) Store(payload []byte) (common.Hash, error) {
+	h := crypto.Keccak256Hash(payload)
+	path := filepath.Join(s.dir, h.Hex())
+	if _, err := os.Stat(path); err == nil {
+		return h, nil
+	}
+	if err := ioutil.WriteFile(path, payload, 0600); err != nil {
+		return common.Hash{}, err
+	}
+	return h, nil
+}
+
+func (s *filePayloadStore) Retrieve(hash common.Hash) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(s.dir, hash.Hex()))
+}
+
+// httpPayloadStore talks to an external Quorum-style transaction manager
+// over its REST API, reachable at the configured URL.
+type httpPayloadStore struct {
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func newHTTPPayloadStore(endpoint *url.URL) (*httpPayloadStore, error) {
+	return &httpPayloadStore{endpoint: endpoint, client: http.DefaultClient}, nil
+}
+
+func (s *httpPayloadStore) Store(payload []byte) (common.Hash, error) {
+	resp, err := s.client.Post(s.endpoint.String()+"/storeraw", "application/octet-stream", bytes.NewReader(payload))
+	if err != nil {
+		return common.Hash{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return common.Hash{}, fmt.Errorf("payload store returned status %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(body), nil
+}
+
+func (s *httpPayloadStore) Retrieve(hash common.Hash) ([]byte, error) {
+	resp, err := s.client.Get(fmt.Sprintf("%s/receiveraw?hash=%s", s.endpoint.String(), hash.Hex()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("payload store returned status %d", resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
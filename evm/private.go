@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2017-2020 The qitmeer developers
+ */
+
+package evm
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// PayloadStore stores and retrieves the encrypted payload of a private
+// transaction off-chain, the way Quorum's private transaction manager
+// (Tessera/Constellation) keeps only a hash on the public chain. The URL
+// scheme selects the backing implementation, mirroring the PRIVATE_CONFIG
+// convention: "file://" for a local directory (development/testing) and
+// "http://" / "https://" for a remote transaction manager.
+type PayloadStore interface {
+	// Store persists payload and returns the hash that is published on
+	// the public EVM state in place of the payload itself.
+	Store(payload []byte) (common.Hash, error)
+	// Retrieve returns the payload previously stored under hash, or an
+	// error if this node does not hold it (e.g. it is not a participant
+	// of the private group the payload belongs to).
+	Retrieve(hash common.Hash) ([]byte, error)
+}
+
+// NewPayloadStore builds the PayloadStore configured via storeURL, matching
+// the PRIVATE_CONFIG style used to point at an external Quorum-style
+// transaction manager.
+func NewPayloadStore(storeURL string) (PayloadStore, error) {
+	u, err := url.Parse(storeURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private payload store url %q: %v", storeURL, err)
+	}
+	switch u.Scheme {
+	case "file":
+		return newFilePayloadStore(u.Path)
+	case "http", "https":
+		return newHTTPPayloadStore(u)
+	default:
+		return nil, fmt.Errorf("unsupported private payload store scheme %q", u.Scheme)
+	}
+}
+
+// PrivateTxManager wires VM's cross-chain private transactions to a
+// PayloadStore, keeping the decrypted payload off the public chain entirely
+// - only Store's returned hash ever reaches public EVM state.
+type PrivateTxManager struct {
+	store PayloadStore
+}
+
+func NewPrivateTxManager(storeURL string) (*PrivateTxManager, error) {
+	store, err := NewPayloadStore(storeURL)
+	if err != nil {
+		return nil, err
+	}
+	return &PrivateTxManager{store: store}, nil
+}
+
+func (m *PrivateTxManager) Store(payload []byte) (common.Hash, error) {
+	return m.store.Store(payload)
+}
+
+func (m *PrivateTxManager) Retrieve(hash common.Hash) ([]byte, error) {
+	return m.store.Retrieve(hash)
+}
@@ -18,11 +18,15 @@ import (
 	"github.com/Qitmeer/qng-core/rpc/api"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/misc"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+	"math/big"
 	"runtime"
 	"sync"
 	"time"
@@ -44,6 +48,25 @@ type VM struct {
 	chain  *chain.ETHChain
 	mchain *chain.MeerChain
 
+	// private manages the off-chain payload store for private
+	// (Quorum-style) cross-chain transactions. It is nil when the node
+	// has not been configured with a private payload store.
+	private *PrivateTxManager
+
+	// privateState tracks the private trie produced by applying private
+	// transactions block by block. It is nil exactly when private is
+	// nil.
+	privateState *chain.PrivateStateManager
+
+	// l1 gates cross-chain transactions on L1 deposit confirmations. It
+	// is nil when the node has not been configured with an L1 endpoint.
+	l1 *L1Bridge
+
+	// beacon ingests drand-style randomness rounds for the beacon
+	// precompile. It is nil when the node has not been configured with
+	// any beacon networks.
+	beacon *chain.BeaconNetworks
+
 	txsCh  chan core.NewTxsEvent
 	txsSub event.Subscription
 }
@@ -71,6 +94,43 @@ func (vm *VM) Initialize(ctx consensus.Context) error {
 	vm.chain = ethchain
 	vm.mchain = chain.NewMeerChain(ethchain)
 
+	// Precompile plugins and the beacon randomness precompile below both
+	// mutate go-ethereum's package-global PrecompiledContracts* tables,
+	// which the EVM interpreter reads without a lock once execution
+	// starts. They must therefore be registered here, before
+	// vm.chain.Start() runs in Bootstrapping, never afterwards.
+	if err := ethchain.LoadPrecompilePlugins(ctx.GetConfig().PrecompilePluginDir); err != nil {
+		return err
+	}
+
+	if len(ctx.GetConfig().BeaconNetworks) > 0 {
+		beacon := chain.NewBeaconNetworks(ctx.GetConfig().BeaconNetworks)
+		if err := ethchain.RegisterBeaconPrecompile(beacon, []string{"Istanbul", "Berlin", "Cancun"}); err != nil {
+			return err
+		}
+		vm.beacon = beacon
+		log.Info("Beacon randomness networks enabled", "count", len(ctx.GetConfig().BeaconNetworks))
+	}
+
+	if len(ctx.GetConfig().PrivateConfig) > 0 {
+		private, err := NewPrivateTxManager(ctx.GetConfig().PrivateConfig)
+		if err != nil {
+			return err
+		}
+		vm.private = private
+		vm.privateState = chain.NewPrivateStateManager(ethchain, ctx.GetConfig().DataDir)
+		log.Info("Private transaction support enabled", "store", ctx.GetConfig().PrivateConfig)
+	}
+
+	if len(ctx.GetConfig().L1Endpoint) > 0 {
+		l1, err := NewL1Bridge(ctx.GetConfig().L1Endpoint, ctx.GetConfig().L1Confirmations, ctx.GetConfig().L1DeploymentBlock, ctx.GetConfig().L1DepositContract, ctx.GetConfig().DataDir)
+		if err != nil {
+			return err
+		}
+		vm.l1 = l1
+		log.Info("L1 anchoring bridge enabled", "endpoint", ctx.GetConfig().L1Endpoint, "confirmations", ctx.GetConfig().L1Confirmations)
+	}
+
 	vm.txsSub = ethchain.Ether().TxPool().SubscribeNewTxsEvent(vm.txsCh)
 
 	vm.shutdownWg.Add(1)
@@ -85,6 +145,12 @@ func (vm *VM) Bootstrapping() error {
 	if err != nil {
 		return err
 	}
+	if vm.l1 != nil {
+		vm.l1.Start()
+	}
+	if vm.beacon != nil {
+		vm.beacon.Start()
+	}
 	//
 	rpcClient, err := vm.chain.Node().Attach()
 	if err != nil {
@@ -134,6 +200,12 @@ func (vm *VM) Shutdown() error {
 	}
 
 	close(vm.shutdownChan)
+	if vm.l1 != nil {
+		vm.l1.Stop()
+	}
+	if vm.beacon != nil {
+		vm.beacon.Stop()
+	}
 	vm.chain.Stop()
 
 	vm.chain.Wait()
@@ -169,7 +241,122 @@ func (vm *VM) GetBlock(bh *hash.Hash) (consensus.Block, error) {
 }
 
 func (vm *VM) BuildBlock(txs []consensus.Tx) (consensus.Block, error) {
-	return nil, nil
+	ethTxs, privateTxs, err := vm.splitCrossChainTxs(txs)
+	if err != nil {
+		return nil, err
+	}
+
+	bc := vm.chain.Ether().BlockChain()
+	parent := bc.CurrentBlock()
+	statedb, err := bc.StateAt(parent.Root())
+	if err != nil {
+		return nil, err
+	}
+
+	chainConfig := bc.Config()
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), big.NewInt(1)),
+		GasLimit:   parent.GasLimit(),
+		// Derived from the parent block rather than the wall clock, so
+		// two nodes building the same block from the same txs produce
+		// the same header: consensus, not this function, is what decides
+		// when a block is actually accepted.
+		Time:     parent.Time() + 1,
+		Coinbase: vm.chain.Config().Eth.Miner.Etherbase,
+	}
+	if chainConfig.IsLondon(header.Number) {
+		header.BaseFee = misc.CalcBaseFee(chainConfig, parent.Header())
+	}
+	if vm.beacon != nil {
+		vm.beacon.SetCurrentBlock(header.Number.Uint64())
+	}
+
+	signer := types.MakeSigner(chainConfig, header.Number, header.Time)
+	txsByFrom := make(map[common.Address]types.Transactions)
+	for _, tx := range ethTxs {
+		from, err := types.Sender(signer, tx)
+		if err != nil {
+			log.Warn(fmt.Sprintf("BuildBlock: skip tx(%s): %v", tx.Hash().String(), err))
+			continue
+		}
+		txsByFrom[from] = append(txsByFrom[from], tx)
+	}
+	// types.NewTransactionsByPriceAndNonce merges each sender's txs back
+	// together ordered by nonce within that sender, highest-tip first
+	// across senders - the same ordering go-ethereum's own miner uses, so
+	// per-account nonce gaps never cause core.ApplyTransaction to reject
+	// a later tx whose earlier nonce simply hasn't been seen yet.
+	txset := types.NewTransactionsByPriceAndNonce(signer, txsByFrom, header.BaseFee)
+
+	gasPool := new(core.GasPool).AddGas(header.GasLimit)
+	var (
+		included []*types.Transaction
+		receipts []*types.Receipt
+		usedGas  uint64
+	)
+	for i := 0; txset.Peek() != nil; i++ {
+		tx := txset.Peek()
+		statedb.Prepare(tx.Hash(), i)
+		receipt, err := core.ApplyTransaction(chainConfig, bc, &header.Coinbase, gasPool, statedb, header, tx, &usedGas, *bc.GetVMConfig())
+		if err != nil {
+			log.Warn(fmt.Sprintf("BuildBlock: skip tx(%s): %v", tx.Hash().String(), err))
+			txset.Pop()
+			continue
+		}
+		included = append(included, tx)
+		receipts = append(receipts, receipt)
+		txset.Shift()
+	}
+	header.GasUsed = usedGas
+	header.Root = statedb.IntermediateRoot(chainConfig.IsEIP158(header.Number))
+
+	ethBlock := types.NewBlock(header, included, nil, receipts, trie.NewStackTrie(nil))
+	h := hash.MustBytesToHash(ethBlock.Hash().Bytes())
+
+	// privateTxs never entered ethTxs/statedb above, so they are neither
+	// executed against nor visible in the public block or its state root -
+	// recording them here, keyed by the public block's own hash, is the
+	// only place this node's knowledge of them is persisted until
+	// ConnectBlock applies them to the private trie via TakePending.
+	if vm.private != nil {
+		if err := vm.privateState.RecordPending(ethBlock.Hash(), privateTxs); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Block{id: &h, ethBlock: ethBlock, vm: vm, status: consensus.Processing}, nil
+}
+
+// splitCrossChainTxs decodes the QNG-wrapped TxTypeCrossChainVM and
+// TxTypeCrossChainVMPrivate transactions back into their underlying
+// go-ethereum transactions, keeping the two kinds apart: public carries
+// public transactions destined for the public block/state, private carries
+// the ones destined solely for the private trie. Private transactions this
+// node does not hold the payload for are skipped rather than failing the
+// whole block, the same way a non-participant of a Quorum private group
+// simply never executes it.
+func (vm *VM) splitCrossChainTxs(txs []consensus.Tx) (public []*types.Transaction, private []*types.Transaction, err error) {
+	public = make([]*types.Transaction, 0, len(txs))
+	for _, tx := range txs {
+		switch tx.GetTxType() {
+		case qtypes.TxTypeCrossChainVM:
+			txb := common.FromHex(string(tx.GetData()))
+			txe := &types.Transaction{}
+			if err := txe.UnmarshalBinary(txb); err != nil {
+				return nil, nil, fmt.Errorf("rlp decoding failed: %v", err)
+			}
+			public = append(public, txe)
+		case qtypes.TxTypeCrossChainVMPrivate:
+			txe, err := vm.resolvePrivateTx(tx)
+			if err != nil {
+				log.Warn(fmt.Sprintf("BuildBlock: skip private tx: %v", err))
+				continue
+			}
+			private = append(private, txe)
+		}
+	}
+	return public, private, nil
 }
 
 func (vm *VM) CheckConnectBlock(block consensus.Block) error {
@@ -177,11 +364,53 @@ func (vm *VM) CheckConnectBlock(block consensus.Block) error {
 }
 
 func (vm *VM) ConnectBlock(block consensus.Block) error {
-	return vm.mchain.ConnectBlock(block)
+	if err := vm.mchain.ConnectBlock(block); err != nil {
+		return err
+	}
+	if vm.private == nil {
+		return nil
+	}
+	return vm.applyPrivateTxsInBlock(block)
 }
 
 func (vm *VM) DisconnectBlock(block consensus.Block) error {
-	return vm.mchain.DisconnectBlock(block)
+	if err := vm.mchain.DisconnectBlock(block); err != nil {
+		return err
+	}
+	if vm.private == nil {
+		return nil
+	}
+	if b, ok := block.(*Block); ok {
+		vm.privateState.Forget(b.ethBlock.Hash())
+	}
+	return nil
+}
+
+// applyPrivateTxsInBlock looks up the private transactions BuildBlock
+// recorded for this block (keyed by its hash, via RecordPending - derived
+// from the QNG TxTypeCrossChainVMPrivate entries BuildBlock saw, not from
+// anything in the public go-ethereum block) and executes them against the
+// private trie, so GetPrivateBalance and private RPC calls observe their
+// effect. TakePending persists its bookkeeping to disk, so this works the
+// same after a restart as it does right after BuildBlock ran.
+func (vm *VM) applyPrivateTxsInBlock(block consensus.Block) error {
+	b, ok := block.(*Block)
+	if !ok {
+		return nil
+	}
+
+	privateTxs, err := vm.privateState.TakePending(b.ethBlock.Hash())
+	if err != nil {
+		return err
+	}
+	if vm.beacon != nil {
+		vm.beacon.SetCurrentBlock(b.ethBlock.NumberU64())
+	}
+
+	// ApplyBlock is called even with no private txs so the parent's
+	// private root is carried forward to this block.
+	_, _, err = vm.privateState.ApplyBlock(b.ethBlock.Header(), privateTxs)
+	return err
 }
 
 func (vm *VM) ParseBlock([]byte) (consensus.Block, error) {
@@ -220,14 +449,53 @@ func (vm *VM) GetBalance(addre string) (int64, error) {
 	return ba.Int64(), nil
 }
 
+// GetPrivateBalance is the private-state counterpart of GetBalance: it
+// reads from the private trie maintained alongside the public state, which
+// only reflects private transactions this node is a participant of.
+func (vm *VM) GetPrivateBalance(addre string) (int64, error) {
+	if vm.private == nil {
+		return 0, fmt.Errorf("private transactions are not enabled on this node")
+	}
+	addr, err := address.DecodeAddress(addre)
+	if err != nil {
+		return 0, err
+	}
+	secpPksAddr, ok := addr.(*address.SecpPubKeyAddress)
+	if !ok {
+		return 0, fmt.Errorf("Not SecpPubKeyAddress:%s", addr.String())
+	}
+	publicKey, err := crypto.UnmarshalPubkey(secpPksAddr.PubKey().SerializeUncompressed())
+	if err != nil {
+		return 0, err
+	}
+	eAddr := crypto.PubkeyToAddress(*publicKey)
+	privateState, err := vm.privateState.StateAt(vm.chain.Ether().BlockChain().CurrentBlock().Hash())
+	if err != nil {
+		return 0, err
+	}
+	ba := privateState.GetBalance(eAddr)
+	if ba == nil {
+		return 0, fmt.Errorf("No private balance for address %s", eAddr)
+	}
+	ba = ba.Div(ba, qcommon.Precision)
+	return ba.Int64(), nil
+}
+
 func (vm *VM) VerifyTx(tx consensus.Tx) (int64, error) {
-	if tx.GetTxType() == qtypes.TxTypeCrossChainVM {
+	vctx := vm.currentValidationContext()
+	switch tx.GetTxType() {
+	case qtypes.TxTypeCrossChainVM:
 		txb := common.FromHex(string(tx.GetData()))
 		var txe = &types.Transaction{}
 		if err := txe.UnmarshalBinary(txb); err != nil {
 			return 0, fmt.Errorf("rlp decoding failed: %v", err)
 		}
-		err := vm.validateTx(txe)
+		if vm.l1 != nil {
+			if err := vm.l1.RequireConfirmedDeposit(txe); err != nil {
+				return 0, err
+			}
+		}
+		err := vm.validateTx(txe, vctx)
 		if err != nil {
 			return 0, err
 		}
@@ -235,29 +503,120 @@ func (vm *VM) VerifyTx(tx consensus.Tx) (int64, error) {
 		cost = cost.Sub(cost, txe.Value())
 		cost = cost.Div(cost, qcommon.Precision)
 		return cost.Int64(), nil
+	case qtypes.TxTypeCrossChainVMPrivate:
+		txe, err := vm.resolvePrivateTx(tx)
+		if err != nil {
+			return 0, err
+		}
+		if err := vm.validateTx(txe, vctx); err != nil {
+			return 0, err
+		}
+		cost := txe.Cost()
+		cost = cost.Sub(cost, txe.Value())
+		cost = cost.Div(cost, qcommon.Precision)
+		return cost.Int64(), nil
+	default:
+		return 0, fmt.Errorf("Not support")
+	}
+}
+
+// resolvePrivateTx dereferences the payload hash carried by a
+// TxTypeCrossChainVMPrivate tx through the private payload store and
+// decodes the resulting go-ethereum transaction. Nodes that are not a
+// participant of the private group the payload belongs to will not hold
+// it and return an error here, same as Quorum's private state behavior.
+func (vm *VM) resolvePrivateTx(tx consensus.Tx) (*types.Transaction, error) {
+	if vm.private == nil {
+		return nil, fmt.Errorf("private transactions are not enabled on this node")
+	}
+	payloadHash := common.BytesToHash(tx.GetData())
+	payload, err := vm.private.Retrieve(payloadHash)
+	if err != nil {
+		return nil, fmt.Errorf("retrieve private payload %s: %v", payloadHash.String(), err)
 	}
-	return 0, fmt.Errorf("Not support")
+	txe := &types.Transaction{}
+	if err := txe.UnmarshalBinary(payload); err != nil {
+		return nil, fmt.Errorf("rlp decoding failed: %v", err)
+	}
+	return txe, nil
+}
+
+// TxValidationContext carries the block coordinates a queued cross-chain
+// transaction is validated against, so the signer and intrinsic-gas rules
+// honor whichever hard fork is active there instead of always the latest
+// one. BlockNumber and BlockTime are threaded into types.MakeSigner the
+// same way go-ethereum's miner derives the signer for a block it is
+// building.
+type TxValidationContext struct {
+	BlockNumber *big.Int
+	BlockTime   uint64
+	BaseFee     *big.Int
 }
 
-func (vm *VM) validateTx(tx *types.Transaction) error {
+// currentValidationContext builds the TxValidationContext for the next
+// block on top of the chain's current head, which is what queued
+// transactions are validated against before they are accepted into the
+// pool or a block under construction.
+func (vm *VM) currentValidationContext() *TxValidationContext {
+	current := vm.chain.Ether().BlockChain().CurrentBlock()
+	chainConfig := vm.chain.Ether().BlockChain().Config()
+	number := new(big.Int).Add(current.Number(), big.NewInt(1))
+	var baseFee *big.Int
+	if chainConfig.IsLondon(number) {
+		baseFee = misc.CalcBaseFee(chainConfig, current.Header())
+	}
+	return &TxValidationContext{
+		BlockNumber: number,
+		BlockTime:   uint64(time.Now().Unix()),
+		BaseFee:     baseFee,
+	}
+}
+
+// validateTxStateless runs the checks on tx and vctx that depend only on
+// the transaction itself and the block coordinates it is validated
+// against - signer/fork selection, fee sanity, and intrinsic gas - none of
+// which need the live chain state that validateTx additionally checks. It
+// is split out so the fork-dependent signer and intrinsic-gas rules can be
+// exercised directly in tests without standing up a full ETHChain, and
+// returns the recovered sender so validateTx doesn't re-derive it.
+func validateTxStateless(chainConfig *params.ChainConfig, tx *types.Transaction, vctx *TxValidationContext) (common.Address, error) {
 	if uint64(tx.Size()) > txMaxSize {
-		return core.ErrOversizedData
+		return common.Address{}, core.ErrOversizedData
 	}
 	if tx.Value().Sign() < 0 {
-		return core.ErrNegativeValue
+		return common.Address{}, core.ErrNegativeValue
 	}
 	if tx.GasFeeCap().BitLen() > 256 {
-		return core.ErrFeeCapVeryHigh
+		return common.Address{}, core.ErrFeeCapVeryHigh
 	}
 	if tx.GasTipCap().BitLen() > 256 {
-		return core.ErrTipVeryHigh
+		return common.Address{}, core.ErrTipVeryHigh
 	}
 	if tx.GasFeeCapIntCmp(tx.GasTipCap()) < 0 {
-		return core.ErrTipAboveFeeCap
+		return common.Address{}, core.ErrTipAboveFeeCap
 	}
-	from, err := types.Sender(types.LatestSigner(vm.chain.Ether().BlockChain().Config()), tx)
+	if vctx.BaseFee != nil && tx.Type() != types.LegacyTxType && tx.GasFeeCapIntCmp(vctx.BaseFee) < 0 {
+		return common.Address{}, core.ErrFeeCapTooLow
+	}
+	signer := types.MakeSigner(chainConfig, vctx.BlockNumber, vctx.BlockTime)
+	from, err := types.Sender(signer, tx)
 	if err != nil {
-		return core.ErrInvalidSender
+		return common.Address{}, core.ErrInvalidSender
+	}
+	intrGas, err := core.IntrinsicGas(tx.Data(), tx.AccessList(), tx.To() == nil, chainConfig.IsHomestead(vctx.BlockNumber), chainConfig.IsIstanbul(vctx.BlockNumber))
+	if err != nil {
+		return common.Address{}, err
+	}
+	if tx.Gas() < intrGas {
+		return common.Address{}, core.ErrIntrinsicGas
+	}
+	return from, nil
+}
+
+func (vm *VM) validateTx(tx *types.Transaction, vctx *TxValidationContext) error {
+	from, err := validateTxStateless(vm.chain.Ether().BlockChain().Config(), tx, vctx)
+	if err != nil {
+		return err
 	}
 	currentState, err := vm.chain.Ether().BlockChain().State()
 	if err != nil {
@@ -269,17 +628,14 @@ func (vm *VM) validateTx(tx *types.Transaction) error {
 	if currentState.GetBalance(from).Cmp(tx.Cost()) < 0 {
 		return core.ErrInsufficientFunds
 	}
-	intrGas, err := core.IntrinsicGas(tx.Data(), tx.AccessList(), tx.To() == nil, true, true)
-	if err != nil {
-		return err
-	}
-	if tx.Gas() < intrGas {
-		return core.ErrIntrinsicGas
-	}
 	return nil
 }
 
 func (vm *VM) addTx(tx *types.Transaction) (*qtypes.Transaction, error) {
+	if err := vm.validateTx(tx, vm.currentValidationContext()); err != nil {
+		return nil, err
+	}
+
 	txmb, err := tx.MarshalBinary()
 	if err != nil {
 		return nil, err
@@ -312,11 +668,61 @@ func (vm *VM) addTx(tx *types.Transaction) (*qtypes.Transaction, error) {
 	return mtx, nil
 }
 
+// addPrivateTx is the private-state counterpart of addTx: the full signed
+// transaction is pushed to the off-chain payload store, and only the
+// resulting payload hash is wrapped into the QNG cross-chain tx, so
+// non-participants never see the transaction's contents.
+func (vm *VM) addPrivateTx(tx *types.Transaction) (*qtypes.Transaction, error) {
+	if vm.private == nil {
+		return nil, fmt.Errorf("private transactions are not enabled on this node")
+	}
+	if err := vm.validateTx(tx, vm.currentValidationContext()); err != nil {
+		return nil, err
+	}
+	txmb, err := tx.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	payloadHash, err := vm.private.Store(txmb)
+	if err != nil {
+		return nil, err
+	}
+
+	qtxhb := tx.Hash().Bytes()
+	qcommon.ReverseBytes(&qtxhb)
+	qtxh := hash.MustBytesToHash(qtxhb)
+
+	mtx := qtypes.NewTransaction()
+	mtx.AddTxIn(&qtypes.TxInput{
+		PreviousOut: *qtypes.NewOutPoint(&qtxh, qtypes.SupperPrevOutIndex),
+		Sequence:    uint32(qtypes.TxTypeCrossChainVMPrivate),
+		AmountIn:    qtypes.Amount{Id: qtypes.ETHID, Value: 0},
+		SignScript:  payloadHash.Bytes(),
+	})
+	mtx.AddTxOut(&qtypes.TxOutput{
+		Amount:   qtypes.Amount{Value: 0, Id: qtypes.ETHID},
+		PkScript: opreturn.NewEVMPrivateTx().PKScript(),
+	})
+
+	acceptedTxs, err := vm.ctx.GetTxPool().ProcessTransaction(qtypes.NewTx(mtx), false, false, true)
+	if err != nil {
+		return nil, err
+	}
+	vm.ctx.GetNotify().AnnounceNewTransactions(acceptedTxs, nil)
+	vm.ctx.GetNotify().AddRebroadcastInventory(acceptedTxs)
+
+	return mtx, nil
+}
+
 func (vm *VM) sendTxs(txs []*types.Transaction) {
 	for _, tx := range txs {
 		qtx, err := vm.addTx(tx)
 		if err != nil {
-			log.Error(fmt.Sprintf("Ignore evm tx(%s)[Exist in qng tx(%s)] from tx pool:%v", tx.Hash().String(), qtx.TxHash(), err.Error()))
+			if qtx != nil {
+				log.Error(fmt.Sprintf("Ignore evm tx(%s)[Exist in qng tx(%s)] from tx pool:%v", tx.Hash().String(), qtx.TxHash(), err.Error()))
+			} else {
+				log.Error(fmt.Sprintf("Ignore evm tx(%s) from tx pool:%v", tx.Hash().String(), err.Error()))
+			}
 			vm.chain.Ether().TxPool().RemoveTx(tx.Hash(), true)
 		}
 	}
@@ -370,6 +776,9 @@ cleanup:
 }
 
 func (vm *VM) RegisterAPIs(apis []api.API) {
+	if vm.l1 != nil {
+		apis = append(apis, vm.l1.APIs()...)
+	}
 	vm.mchain.RegisterAPIs(apis)
 }
 
@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2017-2020 The qitmeer developers
+ */
+
+package evm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+// testChainConfig activates EIP-155 at block 10, Berlin (EIP-2930) at block
+// 20, London (EIP-1559) at block 30, and Cancun (EIP-4844) at time 1000, so a
+// single TxValidationContext on either side of each boundary exercises a
+// different types.Signer, the way a real node validates queued transactions
+// against whichever fork is active at the next block.
+func testChainConfig() *params.ChainConfig {
+	cancunTime := uint64(1000)
+	return &params.ChainConfig{
+		ChainID:             big.NewInt(1337),
+		HomesteadBlock:      big.NewInt(0),
+		EIP150Block:         big.NewInt(0),
+		EIP155Block:         big.NewInt(10),
+		EIP158Block:         big.NewInt(10),
+		ByzantiumBlock:      big.NewInt(10),
+		ConstantinopleBlock: big.NewInt(10),
+		PetersburgBlock:     big.NewInt(10),
+		IstanbulBlock:       big.NewInt(10),
+		BerlinBlock:         big.NewInt(20),
+		LondonBlock:         big.NewInt(30),
+		ShanghaiTime:        &cancunTime,
+		CancunTime:          &cancunTime,
+	}
+}
+
+func mustSign(t *testing.T, signer types.Signer, tx *types.Transaction) (*types.Transaction, common.Address) {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	signed, err := types.SignTx(tx, signer, key)
+	if err != nil {
+		t.Fatalf("sign tx: %v", err)
+	}
+	return signed, crypto.PubkeyToAddress(key.PublicKey)
+}
+
+func TestValidateTxStateless_LegacyTxAcrossEIP155Boundary(t *testing.T) {
+	chainConfig := testChainConfig()
+	to := common.HexToAddress("0x0102030405060708090001020304050607080900")
+	signer := types.NewEIP155Signer(chainConfig.ChainID)
+	tx, from := mustSign(t, signer, types.NewTx(&types.LegacyTx{
+		Nonce:    0,
+		To:       &to,
+		Value:    big.NewInt(0),
+		Gas:      params.TxGas,
+		GasPrice: big.NewInt(1),
+	}))
+
+	// Post-EIP155, MakeSigner selects an EIP155Signer matching the one the
+	// tx was signed with, so the sender recovers correctly.
+	got, err := validateTxStateless(chainConfig, tx, &TxValidationContext{
+		BlockNumber: big.NewInt(15),
+		BlockTime:   0,
+	})
+	if err != nil {
+		t.Fatalf("validateTxStateless at block 15: %v", err)
+	}
+	if got != from {
+		t.Fatalf("recovered sender %s, want %s", got.String(), from.String())
+	}
+
+	// Pre-EIP155, MakeSigner selects a HomesteadSigner, which can't recover
+	// a sender from an EIP155 v value - it must be rejected, not silently
+	// attributed to the wrong address.
+	if _, err := validateTxStateless(chainConfig, tx, &TxValidationContext{
+		BlockNumber: big.NewInt(5),
+		BlockTime:   0,
+	}); err != core.ErrInvalidSender {
+		t.Fatalf("validateTxStateless at block 5: got err %v, want %v", err, core.ErrInvalidSender)
+	}
+}
+
+func TestValidateTxStateless_AccessListTxAcrossBerlinBoundary(t *testing.T) {
+	chainConfig := testChainConfig()
+	to := common.HexToAddress("0x0102030405060708090001020304050607080900")
+	signer := types.NewEIP2930Signer(chainConfig.ChainID)
+	tx, from := mustSign(t, signer, types.NewTx(&types.AccessListTx{
+		ChainID:  chainConfig.ChainID,
+		Nonce:    0,
+		To:       &to,
+		Value:    big.NewInt(0),
+		Gas:      params.TxGas,
+		GasPrice: big.NewInt(1),
+	}))
+
+	got, err := validateTxStateless(chainConfig, tx, &TxValidationContext{
+		BlockNumber: big.NewInt(25),
+		BlockTime:   0,
+	})
+	if err != nil {
+		t.Fatalf("validateTxStateless at block 25: %v", err)
+	}
+	if got != from {
+		t.Fatalf("recovered sender %s, want %s", got.String(), from.String())
+	}
+
+	// Pre-Berlin, MakeSigner selects an EIP155Signer, which only accepts
+	// legacy transactions.
+	if _, err := validateTxStateless(chainConfig, tx, &TxValidationContext{
+		BlockNumber: big.NewInt(15),
+		BlockTime:   0,
+	}); err != core.ErrInvalidSender {
+		t.Fatalf("validateTxStateless at block 15: got err %v, want %v", err, core.ErrInvalidSender)
+	}
+}
+
+func TestValidateTxStateless_DynamicFeeTxAcrossLondonBoundary(t *testing.T) {
+	chainConfig := testChainConfig()
+	to := common.HexToAddress("0x0102030405060708090001020304050607080900")
+	signer := types.NewLondonSigner(chainConfig.ChainID)
+	tx, from := mustSign(t, signer, types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainConfig.ChainID,
+		Nonce:     0,
+		To:        &to,
+		Value:     big.NewInt(0),
+		Gas:       params.TxGas,
+		GasTipCap: big.NewInt(1),
+		GasFeeCap: big.NewInt(10),
+	}))
+
+	got, err := validateTxStateless(chainConfig, tx, &TxValidationContext{
+		BlockNumber: big.NewInt(35),
+		BlockTime:   0,
+		BaseFee:     big.NewInt(1),
+	})
+	if err != nil {
+		t.Fatalf("validateTxStateless at block 35: %v", err)
+	}
+	if got != from {
+		t.Fatalf("recovered sender %s, want %s", got.String(), from.String())
+	}
+
+	// A fee cap below the base fee is rejected once London (and its base
+	// fee market) is active.
+	if _, err := validateTxStateless(chainConfig, tx, &TxValidationContext{
+		BlockNumber: big.NewInt(35),
+		BlockTime:   0,
+		BaseFee:     big.NewInt(11),
+	}); err != core.ErrFeeCapTooLow {
+		t.Fatalf("validateTxStateless with BaseFee 11: got err %v, want %v", err, core.ErrFeeCapTooLow)
+	}
+
+	// Pre-London, MakeSigner selects an EIP2930Signer, which doesn't
+	// support the dynamic-fee tx type.
+	if _, err := validateTxStateless(chainConfig, tx, &TxValidationContext{
+		BlockNumber: big.NewInt(25),
+		BlockTime:   0,
+	}); err != core.ErrInvalidSender {
+		t.Fatalf("validateTxStateless at block 25: got err %v, want %v", err, core.ErrInvalidSender)
+	}
+}
+
+func TestValidateTxStateless_BlobTxAcrossCancunBoundary(t *testing.T) {
+	chainConfig := testChainConfig()
+	to := common.HexToAddress("0x0102030405060708090001020304050607080900")
+	signer := types.NewCancunSigner(chainConfig.ChainID)
+	tx, from := mustSign(t, signer, types.NewTx(&types.BlobTx{
+		ChainID:    uint256.MustFromBig(chainConfig.ChainID),
+		Nonce:      0,
+		To:         to,
+		Value:      uint256.NewInt(0),
+		Gas:        params.TxGas,
+		GasTipCap:  uint256.NewInt(1),
+		GasFeeCap:  uint256.NewInt(10),
+		BlobFeeCap: uint256.NewInt(1),
+		BlobHashes: []common.Hash{{0x01}},
+	}))
+
+	got, err := validateTxStateless(chainConfig, tx, &TxValidationContext{
+		BlockNumber: big.NewInt(35),
+		BlockTime:   1000,
+		BaseFee:     big.NewInt(1),
+	})
+	if err != nil {
+		t.Fatalf("validateTxStateless at time 1000: %v", err)
+	}
+	if got != from {
+		t.Fatalf("recovered sender %s, want %s", got.String(), from.String())
+	}
+
+	// Pre-Cancun, MakeSigner selects a LondonSigner, which doesn't support
+	// the blob tx type.
+	if _, err := validateTxStateless(chainConfig, tx, &TxValidationContext{
+		BlockNumber: big.NewInt(35),
+		BlockTime:   999,
+		BaseFee:     big.NewInt(1),
+	}); err != core.ErrInvalidSender {
+		t.Fatalf("validateTxStateless at time 999: got err %v, want %v", err, core.ErrInvalidSender)
+	}
+}
+
+func TestValidateTxStateless_IntrinsicGas(t *testing.T) {
+	chainConfig := testChainConfig()
+	to := common.HexToAddress("0x0102030405060708090001020304050607080900")
+	signer := types.NewLondonSigner(chainConfig.ChainID)
+	tx, _ := mustSign(t, signer, types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainConfig.ChainID,
+		Nonce:     0,
+		To:        &to,
+		Value:     big.NewInt(0),
+		Gas:       params.TxGas - 1,
+		GasTipCap: big.NewInt(1),
+		GasFeeCap: big.NewInt(10),
+	}))
+
+	if _, err := validateTxStateless(chainConfig, tx, &TxValidationContext{
+		BlockNumber: big.NewInt(35),
+		BlockTime:   0,
+		BaseFee:     big.NewInt(1),
+	}); err != core.ErrIntrinsicGas {
+		t.Fatalf("validateTxStateless with too little gas: got err %v, want %v", err, core.ErrIntrinsicGas)
+	}
+}